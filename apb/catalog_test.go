@@ -0,0 +1,143 @@
+package apb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLoadCatalogImagesFollowsLinkHeader - when the registry advertises
+// an RFC5988 Link header, discovery must follow it rather than building
+// its own n/last query params, and stop once a page arrives without one.
+func TestLoadCatalogImagesFollowsLinkHeader(t *testing.T) {
+	var server *httptest.Server
+	requests := 0
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.Header().Set("Link", `<`+server.URL+`/v2/_catalog?n=1&last=one-apb>; rel="next"`)
+			w.Write([]byte(`{"repositories":["one-apb"]}`))
+		case 2:
+			w.Write([]byte(`{"repositories":["two-apb"]}`))
+		default:
+			t.Fatalf("expected discovery to stop after the Link-less second page, got request %d", requests)
+		}
+	}))
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{PageSize: 2})
+
+	images, err := c.loadCatalogImages(server.URL)
+	if err != nil {
+		t.Fatalf("expected loadCatalogImages to succeed, got: %s", err)
+	}
+
+	if len(images) != 2 || images[0].Name != "one-apb" || images[1].Name != "two-apb" {
+		t.Fatalf("expected [one-apb two-apb], got %v", images)
+	}
+}
+
+// TestLoadCatalogImagesFiltersNonAPBRepos - only repositories ending in
+// -apb are returned.
+func TestLoadCatalogImagesFiltersNonAPBRepos(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"repositories":["hello-apb","unrelated-image"]}`))
+	}))
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{})
+
+	images, err := c.loadCatalogImages(server.URL)
+	if err != nil {
+		t.Fatalf("expected loadCatalogImages to succeed, got: %s", err)
+	}
+	if len(images) != 1 || images[0].Name != "hello-apb" {
+		t.Fatalf("expected only [hello-apb], got %v", images)
+	}
+}
+
+// TestLoadCatalogImagesNotFoundIsExplicit - a registry without
+// /v2/_catalog support must surface a clear error, not an empty result.
+func TestLoadCatalogImagesNotFoundIsExplicit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{})
+
+	if _, err := c.loadCatalogImages(server.URL); err == nil {
+		t.Fatalf("expected an error for a registry without /v2/_catalog support")
+	}
+}
+
+// TestNextCatalogPage - the pure page-URL resolver: prefers the Link
+// header, resolves a relative next URL against baseURL, falls back to
+// n/last paging when a page fills pageSize, and ends on a short page.
+func TestNextCatalogPage(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{})
+
+	next := c.nextCatalogPage("http://registry", `</v2/_catalog?last=b-apb>; rel="next"`, []string{"a-apb"}, 1)
+	if next != "http://registry/v2/_catalog?last=b-apb" {
+		t.Fatalf("expected relative Link target resolved against baseURL, got %q", next)
+	}
+
+	next = c.nextCatalogPage("http://registry", "", []string{"a-apb", "b-apb"}, 2)
+	if next != "http://registry/v2/_catalog?n=2&last=b-apb" {
+		t.Fatalf("expected n/last fallback paging, got %q", next)
+	}
+
+	next = c.nextCatalogPage("http://registry", "", []string{"a-apb"}, 2)
+	if next != "" {
+		t.Fatalf("expected a short page to end discovery, got %q", next)
+	}
+}
+
+// TestLoadV1SearchImagesPaginates - pages through /v1/search until
+// num_pages is reached, aggregating results across pages.
+func TestLoadV1SearchImagesPaginates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		switch page {
+		case "1":
+			w.Write([]byte(`{"num_pages":2,"num_results":2,"results":[{"name":"one-apb"}]}`))
+		case "2":
+			w.Write([]byte(`{"num_pages":2,"num_results":2,"results":[{"name":"two-apb"}]}`))
+		default:
+			t.Fatalf("unexpected page %q", page)
+		}
+	}))
+	defer server.Close()
+
+	r := RHCCRegistry{}
+	r.init(RegistryConfig{PageSize: 1}, newTestRegistryClient(RegistryConfig{}).log)
+
+	resp, err := r.loadV1SearchImages(server.URL, "\"*-apb\"")
+	if err != nil {
+		t.Fatalf("expected loadV1SearchImages to succeed, got: %s", err)
+	}
+	if resp.NumResults != 2 || len(resp.Results) != 2 {
+		t.Fatalf("expected 2 aggregated results, got %+v", resp)
+	}
+}
+
+// TestLoadV1SearchImagesNonOKStatus - a non-200 response (e.g. an
+// unauthenticated search on a registry that requires it) must surface
+// as an error rather than being decoded into an empty result.
+func TestLoadV1SearchImagesNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":"forbidden"}`)
+	}))
+	defer server.Close()
+
+	r := RHCCRegistry{}
+	r.init(RegistryConfig{}, newTestRegistryClient(RegistryConfig{}).log)
+
+	if _, err := r.loadV1SearchImages(server.URL, "\"*-apb\""); err == nil {
+		t.Fatalf("expected a non-200 V1 search response to error")
+	}
+}
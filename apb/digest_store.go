@@ -0,0 +1,37 @@
+package apb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// digestStore - tracks the last-approved manifest digest per image so a
+// registry can refuse to silently reload an image whose mutable tag (e.g.
+// `latest`) now resolves to different content.
+type digestStore struct {
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+func newDigestStore() *digestStore {
+	return &digestStore{digests: map[string]string{}}
+}
+
+// checkAndPin - errors if imageName was previously pinned to a different
+// digest than the one supplied. Otherwise records digest as the approved
+// one for imageName. An empty digest is never pinned or checked.
+func (s *digestStore) checkAndPin(imageName, digest string) error {
+	if digest == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pinned, ok := s.digests[imageName]; ok && pinned != digest {
+		return fmt.Errorf("digest for image [%s] changed from %s to %s; refusing to reload without operator approval", imageName, pinned, digest)
+	}
+
+	s.digests[imageName] = digest
+	return nil
+}
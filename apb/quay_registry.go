@@ -0,0 +1,65 @@
+package apb
+
+import (
+	"strings"
+
+	logging "github.com/op/go-logging"
+)
+
+// QuayRegistry - a generic Docker Distribution / Quay-style registry.
+// Unlike RHCCRegistry it has no v1 search endpoint to fall back on, so
+// catalog discovery via /v2/_catalog is the only strategy, and manifests
+// are always read as V2 schema.
+type QuayRegistry struct {
+	registryClient
+}
+
+// Init - Initialize the Quay/OCI registry
+func (q *QuayRegistry) Init(config RegistryConfig, log *logging.Logger) error {
+	log.Debug("QuayRegistry::Init")
+	q.init(config, log)
+	return nil
+}
+
+// LoadSpecs - Load specs by walking /v2/_catalog and filtering for the
+// -apb name suffix.
+func (q QuayRegistry) LoadSpecs() ([]*Spec, int, error) {
+	q.log.Debug("QuayRegistry::LoadSpecs")
+
+	images, err := q.loadCatalogImages(q.cleanHTTPURL(q.config.URL))
+	if err != nil {
+		return []*Spec{}, 0, err
+	}
+
+	q.log.Debug("Found %d images in catalog", len(images))
+
+	specs, failed := q.fetchSpecs(images, q.imageToSpec)
+	if len(failed) > 0 {
+		q.log.Info("Could not load %d of %d images, skipping: %s", len(failed), len(images), strings.Join(failed, ", "))
+	}
+
+	return specs, len(images), nil
+}
+
+// Fetch - loads and returns the Spec for a single named image.
+func (q QuayRegistry) Fetch(imageName string) (*Spec, error) {
+	labels, digest, err := q.fetchV2Labels(q.cleanHTTPURL(q.config.URL), imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.verifyAndDecode(imageName, labels, digest)
+}
+
+func (q QuayRegistry) imageToSpec(image *Image) *Spec {
+	q.log.Debug("QuayRegistry::imageToSpec")
+
+	spec, err := q.Fetch(image.Name)
+	if err != nil {
+		q.log.Info("Skipping image [%s]: %s.", image.Name, err)
+		return nil
+	}
+
+	q.log.Debug("Successfully converted image [%s] into Spec [%s].", image.Name, spec.Name)
+	return spec
+}
@@ -0,0 +1,48 @@
+package apb
+
+import "time"
+
+// tokenBucket - minimal token-bucket rate limiter used to keep registry
+// request volume under control. A nil *tokenBucket is treated as
+// "unlimited" so callers don't need to special-case the disabled case.
+type tokenBucket struct {
+	tokens chan struct{}
+}
+
+// newTokenBucket - builds a tokenBucket that allows ratePerSecond
+// requests per second, refilling one token at a steady cadence. Returns
+// nil when ratePerSecond is not positive, meaning "no limit".
+func newTokenBucket(ratePerSecond int) *tokenBucket {
+	if ratePerSecond <= 0 {
+		return nil
+	}
+
+	tb := &tokenBucket{tokens: make(chan struct{}, ratePerSecond)}
+	for i := 0; i < ratePerSecond; i++ {
+		tb.tokens <- struct{}{}
+	}
+
+	go tb.refill(time.Second / time.Duration(ratePerSecond))
+
+	return tb
+}
+
+func (tb *tokenBucket) refill(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		select {
+		case tb.tokens <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wait - blocks until a token is available. Safe to call on a nil
+// tokenBucket.
+func (tb *tokenBucket) wait() {
+	if tb == nil {
+		return
+	}
+	<-tb.tokens
+}
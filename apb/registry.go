@@ -0,0 +1,43 @@
+package apb
+
+import (
+	"fmt"
+
+	logging "github.com/op/go-logging"
+)
+
+// Registry - abstracts over the different registry backends the broker
+// can source APBs from, so adding support for a new kind of registry
+// doesn't require touching the code that loads the catalog.
+type Registry interface {
+	// Init - configure the registry and prepare it for use.
+	Init(RegistryConfig, *logging.Logger) error
+	// LoadSpecs - discover every APB the registry has and return their
+	// parsed Specs.
+	LoadSpecs() ([]*Spec, int, error)
+	// Fetch - load the Spec for a single, already-known image name.
+	Fetch(imageName string) (*Spec, error)
+}
+
+// NewRegistry - builds and initializes the Registry implementation named
+// by config.Type, so operators can point the broker at RHCC, Quay, an
+// internal Harbor, or a local `docker distribution` without code changes.
+// An empty Type defaults to RHCC for backward compatibility.
+func NewRegistry(config RegistryConfig, log *logging.Logger) (Registry, error) {
+	var reg Registry
+
+	switch config.Type {
+	case "", "rhcc":
+		reg = &RHCCRegistry{}
+	case "quay", "docker_hub", "docker_distribution":
+		reg = &QuayRegistry{}
+	default:
+		return nil, fmt.Errorf("unknown registry type: %s", config.Type)
+	}
+
+	if err := reg.Init(config, log); err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
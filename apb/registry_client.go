@@ -0,0 +1,506 @@
+package apb
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	logging "github.com/op/go-logging"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// manifestV2Type - media type requested/returned for a Docker Registry V2
+// schema 2 manifest.
+const manifestV2Type = "application/vnd.docker.distribution.manifest.v2+json"
+
+// catalogSuffix - images without this trailing fragment are not APBs.
+const catalogSuffix = "-apb"
+
+// defaultPageSize - number of results requested per page when a
+// RegistryConfig doesn't specify one.
+const defaultPageSize = 100
+
+// defaultMaxPages - hard cap on pages fetched per discovery run when a
+// RegistryConfig doesn't specify one, guarding against a runaway registry.
+const defaultMaxPages = 1000
+
+// defaultConcurrency - number of manifest fetches/idle connections per
+// host used when a RegistryConfig doesn't specify one.
+const defaultConcurrency = 10
+
+// httpClientTimeout - overall timeout for a single request to the
+// registry, covering connect, TLS handshake, and body read.
+const httpClientTimeout = 30 * time.Second
+
+// bearerChallengeRegex - parses the individual key="value" pairs out of a
+// `Www-Authenticate: Bearer realm="...",service="...",scope="..."` header.
+var bearerChallengeRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// linkNextRegex - pulls the URL out of an RFC5988 `Link: <...>; rel="next"`
+// response header.
+var linkNextRegex = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// errManifestNotV2 - the narrow signal that a registry genuinely doesn't
+// speak the V2 schema for this image (a 404 fetching the manifest, or a
+// manifest that isn't schema version 2), as distinct from a transport,
+// auth, or config-blob failure partway through an otherwise-valid V2
+// exchange. Only this signal is safe to fall back to fetchV1Labels on: a
+// V1 manifest never carries a Docker-Content-Digest, so treating any
+// fetchV2Labels error as "fall back to V1" would let a MITM'd registry
+// force the downgrade (by breaking the blob fetch, say) to bypass
+// PinDigests.
+var errManifestNotV2 = errors.New("registry did not return a V2 schema manifest")
+
+// specLabels - the APB labels we care about, regardless of which
+// manifest schema or registry they were read from.
+type specLabels struct {
+	Spec    string `json:"com.redhat.apb.spec"`
+	Version string `json:"com.redhat.apb.version"`
+	Sig     string `json:"com.redhat.apb.spec.sig"`
+}
+
+// registryClient - shared plumbing for talking to a Docker Distribution
+// V2 (or V2-compatible) registry: connection pooling, rate limiting, the
+// Bearer token challenge/response flow, paginated catalog discovery, and
+// manifest/config-blob fetching. Embedded by each Registry implementation
+// so they only need to supply their own discovery strategy.
+type registryClient struct {
+	config  RegistryConfig
+	log     *logging.Logger
+	client  *http.Client
+	limiter *tokenBucket
+	digests *digestStore
+	trust   *trustStore
+}
+
+// init - wires up the HTTP client, rate limiter, digest pin store, and
+// trust store from config. Exists so Registry implementations can embed
+// registryClient and call this from their own Init rather than
+// duplicating the setup.
+func (c *registryClient) init(config RegistryConfig, log *logging.Logger) {
+	c.config = config
+	c.log = log
+	c.client = newHTTPClient(config)
+	c.limiter = newTokenBucket(config.RateLimit)
+	c.digests = newDigestStore()
+	c.trust = &trustStore{}
+}
+
+// newHTTPClient - builds an *http.Client tuned for fanning many manifest
+// fetches out to the same registry host, reusing connections instead of
+// the one-shot behavior of http.DefaultClient.
+func newHTTPClient(config RegistryConfig) *http.Client {
+	idleConnsPerHost := config.Concurrency
+	if idleConnsPerHost <= 0 {
+		idleConnsPerHost = defaultConcurrency
+	}
+
+	return &http.Client{
+		Timeout: httpClientTimeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        idleConnsPerHost * 2,
+			MaxIdleConnsPerHost: idleConnsPerHost,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// httpClient - the configured HTTP client, falling back to
+// http.DefaultClient for a registryClient used without init (e.g. tests).
+func (c registryClient) httpClient() *http.Client {
+	if c.client != nil {
+		return c.client
+	}
+	return http.DefaultClient
+}
+
+// do - issues req through the rate limiter and the shared, tuned HTTP
+// client rather than http.DefaultClient.
+func (c registryClient) do(req *http.Request) (*http.Response, error) {
+	c.limiter.wait()
+	return c.httpClient().Do(req)
+}
+
+// cleanHTTPURL - our code expects an HTTP(S) URL; operators are allowed
+// to configure a bare host, so default the scheme to http.
+func (c registryClient) cleanHTTPURL(url string) string {
+	if strings.HasPrefix(url, "http://") == true {
+		return url
+	}
+
+	if strings.HasPrefix(url, "https://") == true {
+		return url
+	}
+
+	url = "http://" + url
+	return url
+}
+
+// doRegistryRequest - issues a GET against the registry, transparently
+// handling the Bearer token challenge flow described in the Docker
+// Registry V2 auth spec: a 401 with a `Www-Authenticate: Bearer ...`
+// header is met with a token request to the advertised realm, then the
+// original request is retried with that token.
+func (c registryClient) doRegistryRequest(url, accept string) (*http.Response, error) {
+	resp, err := c.getWithAccept(url, accept, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("Www-Authenticate")
+	resp.Body.Close()
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return nil, fmt.Errorf("registry returned 401 without a Bearer challenge")
+	}
+
+	token, err := c.fetchBearerToken(challenge)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bearer token: %s", err)
+	}
+
+	return c.getWithAccept(url, accept, token)
+}
+
+func (c registryClient) getWithAccept(url, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not form request: %s", err)
+	}
+
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request: %s", err)
+	}
+	return resp, nil
+}
+
+// fetchBearerToken - parses a `Www-Authenticate: Bearer realm=...,
+// service=..., scope=...` challenge and exchanges it for a token at the
+// advertised realm.
+func (c registryClient) fetchBearerToken(challenge string) (string, error) {
+	params := map[string]string{}
+	for _, match := range bearerChallengeRegex.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("challenge missing realm: %s", challenge)
+	}
+
+	tokenURL := realm
+	query := []string{}
+	if service, ok := params["service"]; ok {
+		query = append(query, "service="+service)
+	}
+	if scope, ok := params["scope"]; ok {
+		query = append(query, "scope="+scope)
+	}
+	if len(query) > 0 {
+		tokenURL += "?" + strings.Join(query, "&")
+	}
+
+	req, err := http.NewRequest("GET", tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	tokenResp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("error decoding token response: %s", err)
+	}
+
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// fetchV2Labels - requests the V2 schema 2 manifest for an image, follows
+// the config blob digest and pulls the APB labels out of it. Also returns
+// the Docker-Content-Digest the manifest was served at, so callers can
+// pin it against future fetches.
+func (c registryClient) fetchV2Labels(url, imageName string) (*specLabels, string, error) {
+	manifestURL := url + "/v2/" + imageName + "/manifests/latest"
+	resp, err := c.doRegistryRequest(manifestURL, manifestV2Type)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errManifestNotV2
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d fetching V2 manifest", resp.StatusCode)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+
+	manifest := struct {
+		SchemaVersion int `json:"schemaVersion"`
+		Config        struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, "", fmt.Errorf("error decoding V2 manifest: %s", err)
+	}
+
+	if manifest.SchemaVersion != 2 || manifest.Config.Digest == "" {
+		return nil, "", errManifestNotV2
+	}
+
+	blobURL := url + "/v2/" + imageName + "/blobs/" + manifest.Config.Digest
+	blobResp, err := c.doRegistryRequest(blobURL, "application/json")
+	if err != nil {
+		return nil, "", fmt.Errorf("error fetching config blob: %s", err)
+	}
+	defer blobResp.Body.Close()
+
+	if blobResp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d fetching config blob", blobResp.StatusCode)
+	}
+
+	conf := struct {
+		Config struct {
+			Labels specLabels `json:"Labels"`
+		} `json:"config"`
+	}{}
+
+	if err := json.NewDecoder(blobResp.Body).Decode(&conf); err != nil {
+		return nil, "", fmt.Errorf("error decoding config blob: %s", err)
+	}
+
+	return &conf.Config.Labels, digest, nil
+}
+
+// decodeSpecYaml - base64-decodes the raw com.redhat.apb.spec label. This
+// is the exact byte sequence a detached signature is computed over, so
+// verifyAndDecode verifies it before unmarshalling rather than decoding
+// twice.
+func decodeSpecYaml(labels *specLabels) ([]byte, error) {
+	if labels == nil || len(labels.Spec) == 0 {
+		return nil, fmt.Errorf("no com.redhat.apb.spec label present")
+	}
+
+	return b64.StdEncoding.DecodeString(labels.Spec)
+}
+
+// verifyAndDecode - the trust gate every Spec passes through before it
+// enters the catalog: verifies the detached signature label per
+// SignatureMode, decodes com.redhat.apb.spec, and only then — once the
+// spec is known trustworthy — pins/checks the manifest digest when
+// PinDigests is set. Pinning before verification would let a rejected,
+// unsigned spec poison the pin store against a later legitimate one. When
+// PinDigests is set, a missing digest (e.g. a caller that fell back to a
+// V1 manifest, which never carries one) is refused outright rather than
+// silently accepted unpinned.
+func (c registryClient) verifyAndDecode(imageName string, labels *specLabels, digest string) (*Spec, error) {
+	decodedSpecYaml, err := decodeSpecYaml(labels)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding spec label: %s", err)
+	}
+
+	if err := c.verifySignature(labels, decodedSpecYaml); err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	if err := yaml.Unmarshal(decodedSpecYaml, spec); err != nil {
+		return nil, fmt.Errorf("error loading spec yaml: %s", err)
+	}
+
+	if c.config.Verification.PinDigests {
+		if digest == "" {
+			return nil, fmt.Errorf("digest pinning is enabled but no digest was returned for image [%s]", imageName)
+		}
+		if err := c.digests.checkAndPin(imageName, digest); err != nil {
+			return nil, err
+		}
+	}
+
+	spec.Digest = digest
+	return spec, nil
+}
+
+// loadCatalogImages - pages through GET /v2/_catalog, following the
+// RFC5988 Link header when the registry sends one, and falling back to
+// the n/last query parameters when it doesn't. Stops once a page arrives
+// with no Link header and fewer than pageSize() repositories, and errors
+// out after maxPages() pages. Only repositories ending in catalogSuffix
+// are returned.
+func (c registryClient) loadCatalogImages(url string) ([]*Image, error) {
+	type catalogResponse struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	var images []*Image
+	pageSize := c.pageSize()
+	next := fmt.Sprintf("%s/v2/_catalog?n=%d", url, pageSize)
+
+	for page := 0; next != ""; page++ {
+		if page >= c.maxPages() {
+			return nil, fmt.Errorf("aborting catalog discovery after %d pages", page)
+		}
+
+		resp, err := c.doRegistryRequest(next, "application/json")
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, fmt.Errorf("registry does not support /v2/_catalog")
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %d listing catalog", resp.StatusCode)
+		}
+
+		var catalog catalogResponse
+		err = json.NewDecoder(resp.Body).Decode(&catalog)
+		linkHeader := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding catalog response: %s", err)
+		}
+
+		for _, name := range catalog.Repositories {
+			if strings.HasSuffix(name, catalogSuffix) {
+				images = append(images, &Image{Name: name})
+			}
+		}
+
+		next = c.nextCatalogPage(url, linkHeader, catalog.Repositories, pageSize)
+	}
+
+	return images, nil
+}
+
+// nextCatalogPage - resolves the next /v2/_catalog page URL, or "" once
+// discovery is complete.
+func (c registryClient) nextCatalogPage(baseURL, linkHeader string, repos []string, pageSize int) string {
+	if linkHeader != "" {
+		if m := linkNextRegex.FindStringSubmatch(linkHeader); m != nil {
+			next := m[1]
+			if strings.HasPrefix(next, "/") {
+				next = baseURL + next
+			}
+			return next
+		}
+	}
+
+	if len(repos) < pageSize {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/v2/_catalog?n=%d&last=%s", baseURL, pageSize, repos[len(repos)-1])
+}
+
+// fetchSpecs - fans fetch out across a bounded worker pool sized by
+// RegistryConfig.Concurrency, instead of fetching manifests one at a
+// time. A per-image failure is non-fatal; the image name is collected and
+// returned so the caller can log a single summary rather than one line
+// per miss.
+func (c registryClient) fetchSpecs(images []*Image, fetch func(*Image) *Spec) ([]*Spec, []string) {
+	type result struct {
+		spec  *Spec
+		image string
+	}
+
+	work := make(chan *Image)
+	results := make(chan result)
+
+	workers := c.concurrency()
+	if workers > len(images) {
+		workers = len(images)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for image := range work {
+				results <- result{spec: fetch(image), image: image.Name}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(work)
+		for _, image := range images {
+			work <- image
+		}
+	}()
+
+	var specs []*Spec
+	var failed []string
+	for range images {
+		res := <-results
+		if res.spec != nil {
+			specs = append(specs, res.spec)
+		} else {
+			failed = append(failed, res.image)
+		}
+	}
+
+	return specs, failed
+}
+
+// pageSize - the configured page size for catalog/search paging, or
+// defaultPageSize when unset.
+func (c registryClient) pageSize() int {
+	if c.config.PageSize > 0 {
+		return c.config.PageSize
+	}
+	return defaultPageSize
+}
+
+// maxPages - the configured hard cap on pages fetched per discovery run,
+// or defaultMaxPages when unset.
+func (c registryClient) maxPages() int {
+	if c.config.MaxPages > 0 {
+		return c.config.MaxPages
+	}
+	return defaultMaxPages
+}
+
+// concurrency - the configured manifest-fetch worker pool size, or
+// defaultConcurrency when unset.
+func (c registryClient) concurrency() int {
+	if c.config.Concurrency > 0 {
+		return c.config.Concurrency
+	}
+	return defaultConcurrency
+}
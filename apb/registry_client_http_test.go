@@ -0,0 +1,118 @@
+package apb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFetchV2LabelsBearerChallengeAndRetry - a 401 on the manifest and
+// blob requests, each carrying a Www-Authenticate Bearer challenge, must
+// be retried against the advertised realm with the exchanged token
+// rather than bubbling up as a hard failure.
+func TestFetchV2LabelsBearerChallengeAndRetry(t *testing.T) {
+	const token = "test-token"
+	var server *httptest.Server
+	tokenRequests := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/test-apb/manifests/latest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("Www-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:test-apb:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+		w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:blobdigest"}}`))
+	})
+	mux.HandleFunc("/v2/test-apb/blobs/sha256:blobdigest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("Www-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:test-apb:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"config":{"Labels":{"com.redhat.apb.spec":"bmFtZTogdGVzdA=="}}}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Write([]byte(`{"token":"` + token + `"}`))
+	})
+
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{})
+
+	labels, digest, err := c.fetchV2Labels(server.URL, "test-apb")
+	if err != nil {
+		t.Fatalf("expected fetchV2Labels to succeed after the bearer retry, got: %s", err)
+	}
+	if digest != "sha256:abc123" {
+		t.Fatalf("expected digest sha256:abc123, got %q", digest)
+	}
+	if labels.Spec == "" {
+		t.Fatalf("expected a decoded com.redhat.apb.spec label")
+	}
+	if tokenRequests == 0 {
+		t.Fatalf("expected the bearer challenge to be exchanged for a token")
+	}
+}
+
+// TestFetchV2LabelsManifestNotFound - a 404 fetching the manifest is the
+// narrow "registry doesn't speak V2" signal callers fall back to V1 on.
+func TestFetchV2LabelsManifestNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{})
+
+	_, _, err := c.fetchV2Labels(server.URL, "test-apb")
+	if err != errManifestNotV2 {
+		t.Fatalf("expected errManifestNotV2, got: %v", err)
+	}
+}
+
+// TestFetchV2LabelsBlobFetchFailureIsHardError - a failure fetching the
+// config blob, after a valid V2 manifest was already returned, must be a
+// hard error rather than falling back to V1 (which would discard the
+// digest the V2 manifest already committed to).
+func TestFetchV2LabelsBlobFetchFailureIsHardError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/test-apb/manifests/latest", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+		w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:blobdigest"}}`))
+	})
+	mux.HandleFunc("/v2/test-apb/blobs/sha256:blobdigest", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{})
+
+	_, _, err := c.fetchV2Labels(server.URL, "test-apb")
+	if err == nil || err == errManifestNotV2 {
+		t.Fatalf("expected a hard error distinct from errManifestNotV2, got: %v", err)
+	}
+}
+
+// TestDoRegistryRequestRejectsNonBearerChallenge - a 401 without a
+// Bearer challenge can't be retried and must be surfaced as an error.
+func TestDoRegistryRequestRejectsNonBearerChallenge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Www-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	c := newTestRegistryClient(RegistryConfig{})
+
+	_, err := c.doRegistryRequest(server.URL, "application/json")
+	if err == nil || !strings.Contains(err.Error(), "without a Bearer challenge") {
+		t.Fatalf("expected a non-Bearer-challenge error, got: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package apb
+
+import (
+	"sort"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+// newTestRegistryClient - a registryClient wired up enough to exercise its
+// pure helpers (no network), mirroring what Init would have produced.
+func newTestRegistryClient(config RegistryConfig) registryClient {
+	c := registryClient{}
+	c.init(config, logging.MustGetLogger("apb_test"))
+	return c
+}
+
+// TestFetchSpecsAggregatesFailures - a failing fetch (nil Spec) must not
+// abort the run; it should be collected into the failed slice while
+// successful fetches still come back as specs, regardless of how the
+// worker pool interleaves them.
+func TestFetchSpecsAggregatesFailures(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{Concurrency: 2})
+
+	images := []*Image{
+		{Name: "good-one-apb"},
+		{Name: "bad-one-apb"},
+		{Name: "good-two-apb"},
+	}
+
+	fetch := func(image *Image) *Spec {
+		if image.Name == "bad-one-apb" {
+			return nil
+		}
+		return &Spec{Name: image.Name}
+	}
+
+	specs, failed := c.fetchSpecs(images, fetch)
+
+	if len(failed) != 1 || failed[0] != "bad-one-apb" {
+		t.Fatalf("expected failed = [bad-one-apb], got %v", failed)
+	}
+
+	var gotNames []string
+	for _, s := range specs {
+		gotNames = append(gotNames, s.Name)
+	}
+	sort.Strings(gotNames)
+
+	wantNames := []string{"good-one-apb", "good-two-apb"}
+	if len(gotNames) != len(wantNames) {
+		t.Fatalf("expected specs %v, got %v", wantNames, gotNames)
+	}
+	for i := range wantNames {
+		if gotNames[i] != wantNames[i] {
+			t.Fatalf("expected specs %v, got %v", wantNames, gotNames)
+		}
+	}
+}
+
+// TestFetchSpecsNoImages - an empty image list must not deadlock the
+// worker pool (workers is clamped to len(images), which is zero here).
+func TestFetchSpecsNoImages(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{Concurrency: 4})
+
+	specs, failed := c.fetchSpecs(nil, func(*Image) *Spec {
+		t.Fatal("fetch should never be called with no images")
+		return nil
+	})
+
+	if len(specs) != 0 || len(failed) != 0 {
+		t.Fatalf("expected no specs or failures, got specs=%v failed=%v", specs, failed)
+	}
+}
@@ -0,0 +1,66 @@
+package apb
+
+// RegistryConfig - Configuration for a registry. Handed to a Registry's
+// Init method so the broker can point at any number of registries without
+// code changes.
+type RegistryConfig struct {
+	Type      string
+	Name      string
+	URL       string
+	User      string
+	Pass      string
+	Org       string
+	Tag       string
+	WhiteList []string
+	BlackList []string
+	Fail      bool
+
+	// PageSize - number of results requested per page when paging through
+	// catalog/search results. Defaults to defaultPageSize when unset.
+	PageSize int
+
+	// MaxPages - hard cap on the number of pages a single discovery run
+	// will fetch, guarding against a runaway or misbehaving registry.
+	// Defaults to defaultMaxPages when unset.
+	MaxPages int
+
+	// Concurrency - number of manifest fetches a Registry may have in
+	// flight at once. Defaults to defaultConcurrency when unset.
+	Concurrency int
+
+	// RateLimit - maximum number of registry requests issued per second.
+	// Zero disables rate limiting.
+	RateLimit int
+
+	// Verification - digest pinning and signature verification settings
+	// applied to every Spec this registry loads.
+	Verification Verification
+}
+
+// Verification - digest pinning / signature verification knobs for a
+// Registry. The zero value disables both: digests aren't pinned and
+// signatures aren't checked.
+type Verification struct {
+	// PinDigests - once an image has been loaded at a given
+	// Docker-Content-Digest, refuse to reload it under a different
+	// digest. Pins are held in memory for the life of the Registry; no
+	// method clears or re-approves a single pin.
+	PinDigests bool
+
+	// TrustStorePath - path to the trust store of trusted public keys
+	// used to verify the com.redhat.apb.spec.sig label. Its format is
+	// given by TrustStoreType.
+	TrustStorePath string
+
+	// TrustStoreType - format of the keyring at TrustStorePath: "" or
+	// "pgp" for an armored PGP keyring, "cosign" for one or more
+	// PEM-encoded ECDSA public keys as produced by
+	// `cosign generate-key-pair`.
+	TrustStoreType string
+
+	// SignatureMode - "required" rejects any Spec that isn't signed by a
+	// trusted key in TrustStorePath; "optional" verifies a signature when
+	// present but still accepts unsigned Specs; "" skips signature
+	// verification entirely.
+	SignatureMode string
+}
@@ -0,0 +1,97 @@
+package apb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+// TestNewRegistryDispatchesByType - NewRegistry must build the
+// implementation named by config.Type, defaulting an empty Type to RHCC,
+// and reject an unrecognized one.
+func TestNewRegistryDispatchesByType(t *testing.T) {
+	log := logging.MustGetLogger("apb_test")
+
+	cases := []struct {
+		regType string
+		want    Registry
+	}{
+		{"", &RHCCRegistry{}},
+		{"rhcc", &RHCCRegistry{}},
+		{"quay", &QuayRegistry{}},
+		{"docker_hub", &QuayRegistry{}},
+		{"docker_distribution", &QuayRegistry{}},
+	}
+
+	for _, tc := range cases {
+		reg, err := NewRegistry(RegistryConfig{Type: tc.regType}, log)
+		if err != nil {
+			t.Fatalf("type %q: expected no error, got: %s", tc.regType, err)
+		}
+
+		switch tc.want.(type) {
+		case *RHCCRegistry:
+			if _, ok := reg.(*RHCCRegistry); !ok {
+				t.Fatalf("type %q: expected *RHCCRegistry, got %T", tc.regType, reg)
+			}
+		case *QuayRegistry:
+			if _, ok := reg.(*QuayRegistry); !ok {
+				t.Fatalf("type %q: expected *QuayRegistry, got %T", tc.regType, reg)
+			}
+		}
+	}
+
+	if _, err := NewRegistry(RegistryConfig{Type: "not-a-real-registry"}, log); err == nil {
+		t.Fatal("expected an error for an unknown registry type")
+	}
+}
+
+// TestQuayRegistryFetchHasNoV1Fallback - unlike RHCCRegistry, QuayRegistry
+// has no V1 search/manifest fallback: a registry that doesn't speak V2
+// must surface as a hard error from Fetch, not be silently retried.
+func TestQuayRegistryFetchHasNoV1Fallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	q := &QuayRegistry{}
+	if err := q.Init(RegistryConfig{URL: server.URL}, logging.MustGetLogger("apb_test")); err != nil {
+		t.Fatalf("expected Init to succeed, got: %s", err)
+	}
+
+	if _, err := q.Fetch("test-apb"); err == nil {
+		t.Fatal("expected Fetch to surface an error rather than falling back")
+	}
+}
+
+// TestQuayRegistryFetchDecodesV2Labels - Fetch on a registry that does
+// speak V2 returns a decoded Spec with its manifest digest attached.
+func TestQuayRegistryFetchDecodesV2Labels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/test-apb/manifests/latest", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abc123")
+		w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:blobdigest"}}`))
+	})
+	mux.HandleFunc("/v2/test-apb/blobs/sha256:blobdigest", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"config":{"Labels":{"com.redhat.apb.spec":"bmFtZTogdGVzdA=="}}}`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	q := &QuayRegistry{}
+	if err := q.Init(RegistryConfig{URL: server.URL}, logging.MustGetLogger("apb_test")); err != nil {
+		t.Fatalf("expected Init to succeed, got: %s", err)
+	}
+
+	spec, err := q.Fetch("test-apb")
+	if err != nil {
+		t.Fatalf("expected Fetch to succeed, got: %s", err)
+	}
+	if spec.Name != "test" || spec.Digest != "sha256:abc123" {
+		t.Fatalf("expected spec {Name: test, Digest: sha256:abc123}, got %+v", spec)
+	}
+}
@@ -1,20 +1,19 @@
 package apb
 
 import (
-	b64 "encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 
 	logging "github.com/op/go-logging"
-	yaml "gopkg.in/yaml.v2"
 )
 
 // RHCCRegistry - Red Hat Container Catalog Registry
 type RHCCRegistry struct {
-	config RegistryConfig
-	log    *logging.Logger
+	registryClient
 }
 
 // Image - RHCC Registry Image that is returned from the RHCC Catalog api.
@@ -37,29 +36,13 @@ type ImageResponse struct {
 // Init - Initialize the Red Hat Container Catalog
 func (r *RHCCRegistry) Init(config RegistryConfig, log *logging.Logger) error {
 	log.Debug("RHCCRegistry::Init")
-	r.config = config
-	r.log = log
+	r.init(config, log)
 	return nil
 }
 
-// This function is used because our code expects an HTTP Url for talking to RHCC
-func (r RHCCRegistry) cleanHTTPURL(url string) string {
-	if strings.HasPrefix(url, "http://") == true {
-		return url
-	}
-
-	if strings.HasPrefix(url, "https://") == true {
-		return url
-	}
-
-	url = "http://" + url
-	return url
-}
-
 // LoadSpecs - Load Red Hat Container Catalog specs
 func (r RHCCRegistry) LoadSpecs() ([]*Spec, int, error) {
 	r.log.Debug("RHCCRegistry::LoadSpecs")
-	var specs []*Spec
 
 	imageList, err := r.LoadImages("\"*-apb\"")
 	if err != nil {
@@ -68,120 +51,156 @@ func (r RHCCRegistry) LoadSpecs() ([]*Spec, int, error) {
 
 	numResults := imageList.NumResults
 	r.log.Debug("Found %d images in RHCC", numResults)
-	for _, image := range imageList.Results {
-		if spec := r.imageToSpec(image); spec != nil {
-			specs = append(specs, spec)
-		}
+
+	specs, failed := r.fetchSpecs(imageList.Results, r.imageToSpec)
+	if len(failed) > 0 {
+		r.log.Info("Could not load %d of %d images, skipping: %s", len(failed), numResults, strings.Join(failed, ", "))
 	}
 
 	return specs, numResults, nil
 }
 
-func (r RHCCRegistry) imageToSpec(image *Image) *Spec {
-	r.log.Debug("RHCCRegistry::imageToSpec")
-	_spec := &Spec{}
+// Fetch - loads and returns the Spec for a single named image.
+func (r RHCCRegistry) Fetch(imageName string) (*Spec, error) {
 	url := r.cleanHTTPURL(r.config.URL)
 
-	req, err := http.NewRequest("GET", url+"/v2/"+image.Name+"/manifests/latest", nil)
+	labels, digest, err := r.fetchV2Labels(url, imageName)
 	if err != nil {
-		r.log.Info("Could not form request. Error: %s. Skipping Image: %s.", err, image.Name)
-		return nil
+		if !errors.Is(err, errManifestNotV2) {
+			return nil, fmt.Errorf("could not load manifest for image [%s]: %s", imageName, err)
+		}
+
+		var v1err error
+		labels, v1err = r.fetchV1Labels(url, imageName)
+		if v1err != nil {
+			return nil, fmt.Errorf("could not load manifest for image [%s]: %s", imageName, err)
+		}
+		// V1 schema manifests don't carry a Docker-Content-Digest header,
+		// so this image can't be pinned until it's served as V2.
+		digest = ""
 	}
 
-	req.Header.Add("Accept", "application/json")
+	return r.verifyAndDecode(imageName, labels, digest)
+}
+
+func (r RHCCRegistry) imageToSpec(image *Image) *Spec {
+	r.log.Debug("RHCCRegistry::imageToSpec")
 
-	resp, err := http.DefaultClient.Do(req)
+	spec, err := r.Fetch(image.Name)
 	if err != nil {
-		r.log.Info("Could not send request. Error: %s. Skipping Image: %s.", err, image.Name)
+		r.log.Info("Skipping image [%s]: %s.", image.Name, err)
 		return nil
 	}
-	defer resp.Body.Close()
 
-	type label struct {
-		Spec    string `json:"com.redhat.apb.spec"`
-		Version string `json:"com.redhat.apb.version"`
-	}
+	r.log.Debug("Successfully converted RHCC Image [%s] into Spec [%s].", image.Name, spec.Name)
+	return spec
+}
 
-	type config struct {
-		Label label `json:"Labels"`
+// fetchV1Labels - legacy fallback for registries that only speak the V1
+// schema manifest format.
+func (r RHCCRegistry) fetchV1Labels(url, imageName string) (*specLabels, error) {
+	resp, err := r.doRegistryRequest(url+"/v2/"+imageName+"/manifests/latest", "application/json")
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	hist := struct {
 		History []map[string]string `json:"history"`
 	}{}
 
-	conf := struct {
-		Config *config `json:"config"`
-	}{}
-
-	err = json.NewDecoder(resp.Body).Decode(&hist)
-	if err != nil {
-		r.log.Info("Error grabbing JSON body from response: %s. Skipping image [%s].", err, image.Name)
-		return nil
+	if err := json.NewDecoder(resp.Body).Decode(&hist); err != nil {
+		return nil, fmt.Errorf("error grabbing JSON body from response: %s", err)
 	}
 
 	if hist.History == nil {
-		r.log.Info("V1 Schema Manifest history does not exist in registry. Skipping image [%s].", image.Name)
-		return nil
-	}
-
-	err = json.Unmarshal([]byte(hist.History[0]["v1Compatibility"]), &conf)
-	if err != nil {
-		r.log.Info("Error unmarshalling intermediary JSON response: %s. Skipping image [%s].", err, image.Name)
-		return nil
+		return nil, fmt.Errorf("V1 Schema Manifest history does not exist in registry")
 	}
 
-	if conf.Config == nil {
-		r.log.Info("Did not find v1 Manifest in image history. Skipping image [%s].", image.Name)
-		return nil
-	}
-
-	encodedSpec := conf.Config.Label.Spec
-	if len(encodedSpec) == 0 {
-		r.log.Info("Didn't find encoded Spec label. Assuming image is not APB and skipping [%s].", image.Name)
-		return nil
-	}
+	conf := struct {
+		Config *struct {
+			Label specLabels `json:"Labels"`
+		} `json:"config"`
+	}{}
 
-	decodedSpecYaml, err := b64.StdEncoding.DecodeString(encodedSpec)
-	if err != nil {
-		r.log.Info("Something went wrong decoding spec from label. Skipping image [%s].", image.Name)
-		return nil
+	if err := json.Unmarshal([]byte(hist.History[0]["v1Compatibility"]), &conf); err != nil {
+		return nil, fmt.Errorf("error unmarshalling intermediary JSON response: %s", err)
 	}
 
-	if err = yaml.Unmarshal(decodedSpecYaml, _spec); err != nil {
-		r.log.Info("Something went wrong loading decoded spec yaml, %s. Skipping image [%s].", err, image.Name)
-		return nil
+	if conf.Config == nil {
+		return nil, fmt.Errorf("did not find v1 Manifest in image history")
 	}
-	r.log.Debug("Successfully converted RHCC Image [%s] into Spec [%s].", image.Name, _spec.Name)
 
-	return _spec
+	return &conf.Config.Label, nil
 }
 
-// LoadImages - Get all the images for a particular query
+// LoadImages - Get all the images for a particular query. Prefers paging
+// through the V2 catalog, which has no notion of a search query, and
+// falls back to paginated V1 search (deprecated, but still the only
+// option on older registries).
 func (r RHCCRegistry) LoadImages(Query string) (ImageResponse, error) {
 	r.log.Debug("RHCCRegistry::LoadImages")
 	url := r.cleanHTTPURL(r.config.URL)
 	r.log.Debug("Using " + url + " to source APB images using query:" + Query)
-	req, err := http.NewRequest("GET", url+"/v1/search?q="+Query, nil)
-	if err != nil {
-		return ImageResponse{}, err
-	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return ImageResponse{}, err
+	images, err := r.loadCatalogImages(url)
+	if err == nil {
+		r.log.Debug("Found %d images via /v2/_catalog", len(images))
+		return ImageResponse{NumResults: len(images), Query: Query, Results: images}, nil
 	}
-	defer resp.Body.Close()
+	r.log.Debug("V2 catalog discovery unavailable (%s). Falling back to V1 search.", err)
 
-	r.log.Debug("Got Image Response from RHCC")
-	imageList, err := ioutil.ReadAll(resp.Body)
+	return r.loadV1SearchImages(url, Query)
+}
 
-	imageResp := ImageResponse{}
-	err = json.Unmarshal(imageList, &imageResp)
-	if err != nil {
-		return ImageResponse{}, err
-	}
-	r.log.Debug("Properly unmarshalled image response")
+// loadV1SearchImages - pages through the deprecated GET /v1/search using
+// its own page/page_size/num_pages paging params, aggregating results
+// until the registry reports there are no more.
+func (r RHCCRegistry) loadV1SearchImages(url, query string) (ImageResponse, error) {
+	pageSize := r.pageSize()
+	var results []*Image
+	page := 1
+
+	for {
+		if page > r.maxPages() {
+			return ImageResponse{}, fmt.Errorf("aborting v1 search after %d pages", page-1)
+		}
+
+		searchURL := fmt.Sprintf("%s/v1/search?q=%s&page=%d&page_size=%d", url, query, page, pageSize)
+		resp, err := r.doRegistryRequest(searchURL, "application/json")
+		if err != nil {
+			return ImageResponse{}, err
+		}
+
+		r.log.Debug("Got Image Response from RHCC, page %d", page)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return ImageResponse{}, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return ImageResponse{}, fmt.Errorf("unexpected status %d from V1 search", resp.StatusCode)
+		}
 
-	return imageResp, nil
-}
\ No newline at end of file
+		pageResp := struct {
+			NumPages   int      `json:"num_pages"`
+			NumResults int      `json:"num_results"`
+			Results    []*Image `json:"results"`
+		}{}
+
+		if err = json.Unmarshal(body, &pageResp); err != nil {
+			return ImageResponse{}, err
+		}
+
+		results = append(results, pageResp.Results...)
+		r.log.Debug("Properly unmarshalled image response")
+
+		if len(pageResp.Results) == 0 || len(results) >= pageResp.NumResults ||
+			(pageResp.NumPages > 0 && page >= pageResp.NumPages) {
+			return ImageResponse{NumResults: len(results), Query: query, Results: results}, nil
+		}
+
+		page++
+	}
+}
@@ -0,0 +1,197 @@
+package apb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSpecYaml = "name: test-apb\nimage: test/test-apb\n"
+
+func testLabels(sig string) *specLabels {
+	return &specLabels{
+		Spec: b64.StdEncoding.EncodeToString([]byte(testSpecYaml)),
+		Sig:  sig,
+	}
+}
+
+// TestVerifyAndDecodeUnsignedMode - SignatureMode "" must skip signature
+// verification entirely, even when the Sig label couldn't possibly be a
+// valid signature.
+func TestVerifyAndDecodeUnsignedMode(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{})
+
+	spec, err := c.verifyAndDecode("test-apb", testLabels("not a real signature"), "")
+	if err != nil {
+		t.Fatalf("expected no error with verification disabled, got: %s", err)
+	}
+	if spec.Name != "test-apb" {
+		t.Fatalf("expected decoded spec name test-apb, got %q", spec.Name)
+	}
+}
+
+// TestVerifyAndDecodeOptionalModeAcceptsUnsigned - SignatureMode "optional"
+// must accept a Spec with no Sig label rather than rejecting it.
+func TestVerifyAndDecodeOptionalModeAcceptsUnsigned(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{
+		Verification: Verification{SignatureMode: "optional"},
+	})
+
+	spec, err := c.verifyAndDecode("test-apb", testLabels(""), "")
+	if err != nil {
+		t.Fatalf("expected no error for unsigned spec under optional mode, got: %s", err)
+	}
+	if spec.Name != "test-apb" {
+		t.Fatalf("expected decoded spec name test-apb, got %q", spec.Name)
+	}
+}
+
+// TestVerifyAndDecodeRequiredModeRejectsUnsigned - SignatureMode
+// "required" must reject a Spec with no Sig label.
+func TestVerifyAndDecodeRequiredModeRejectsUnsigned(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{
+		Verification: Verification{SignatureMode: "required"},
+	})
+
+	_, err := c.verifyAndDecode("test-apb", testLabels(""), "")
+	if err == nil || !strings.Contains(err.Error(), "signature verification is required") {
+		t.Fatalf("expected a required-signature error, got: %v", err)
+	}
+}
+
+// TestVerifyAndDecodeRequiredModeNeedsTrustStore - a signed Spec under
+// "required" mode with no TrustStorePath configured must fail with the
+// trust store load error, not silently pass.
+func TestVerifyAndDecodeRequiredModeNeedsTrustStore(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{
+		Verification: Verification{SignatureMode: "required"},
+	})
+
+	_, err := c.verifyAndDecode("test-apb", testLabels("c2lnbmF0dXJl"), "")
+	if err == nil || !strings.Contains(err.Error(), "could not load trust store") {
+		t.Fatalf("expected a trust store load error, got: %v", err)
+	}
+}
+
+// writeCosignPubKey - PEM-encodes pub and writes it to a temp file under
+// t's test directory, returning its path.
+func writeCosignPubKey(t *testing.T, pub *ecdsa.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to marshal test public key: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create test trust store: %s", err)
+	}
+	defer f.Close()
+
+	if err := pem.Encode(f, &pem.Block{Type: "PUBLIC KEY", Bytes: der}); err != nil {
+		t.Fatalf("failed to PEM-encode test public key: %s", err)
+	}
+
+	return path
+}
+
+// TestVerifyAndDecodeCosignRequiredMode - a spec signed with a trusted
+// cosign key must verify under "required" mode; one signed with an
+// untrusted key must not.
+func TestVerifyAndDecodeCosignRequiredMode(t *testing.T) {
+	trusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %s", err)
+	}
+	untrusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %s", err)
+	}
+
+	trustStorePath := writeCosignPubKey(t, &trusted.PublicKey)
+
+	digest := sha256.Sum256([]byte(testSpecYaml))
+	sig, err := ecdsa.SignASN1(rand.Reader, trusted, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign test spec: %s", err)
+	}
+	encodedSig := b64.StdEncoding.EncodeToString(sig)
+
+	c := newTestRegistryClient(RegistryConfig{
+		Verification: Verification{
+			SignatureMode:  "required",
+			TrustStoreType: trustStoreCosign,
+			TrustStorePath: trustStorePath,
+		},
+	})
+
+	if _, err := c.verifyAndDecode("test-apb", testLabels(encodedSig), ""); err != nil {
+		t.Fatalf("expected signature from trusted cosign key to verify, got: %s", err)
+	}
+
+	untrustedSig, err := ecdsa.SignASN1(rand.Reader, untrusted, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign test spec: %s", err)
+	}
+	encodedUntrustedSig := b64.StdEncoding.EncodeToString(untrustedSig)
+
+	cUntrusted := newTestRegistryClient(RegistryConfig{
+		Verification: Verification{
+			SignatureMode:  "required",
+			TrustStoreType: trustStoreCosign,
+			TrustStorePath: trustStorePath,
+		},
+	})
+	_, err = cUntrusted.verifyAndDecode("test-apb", testLabels(encodedUntrustedSig), "")
+	if err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Fatalf("expected signature from untrusted key to be rejected, got: %v", err)
+	}
+}
+
+// TestVerifyAndDecodePinDigestsRequiresDigest - PinDigests must refuse a
+// Spec fetched with no digest (e.g. one that fell back to a V1
+// manifest) outright, rather than silently accepting it unpinned.
+func TestVerifyAndDecodePinDigestsRequiresDigest(t *testing.T) {
+	c := newTestRegistryClient(RegistryConfig{
+		Verification: Verification{PinDigests: true},
+	})
+
+	_, err := c.verifyAndDecode("test-apb", testLabels(""), "")
+	if err == nil || !strings.Contains(err.Error(), "no digest was returned") {
+		t.Fatalf("expected a missing-digest error, got: %v", err)
+	}
+
+	if _, err := c.verifyAndDecode("test-apb", testLabels(""), "sha256:aaa"); err != nil {
+		t.Fatalf("expected a present digest to pin successfully, got: %s", err)
+	}
+}
+
+// TestDigestStoreCheckAndPinMismatch - once an image is pinned to a
+// digest, a later fetch that resolves to a different digest must be
+// rejected rather than silently re-pinned.
+func TestDigestStoreCheckAndPinMismatch(t *testing.T) {
+	s := newDigestStore()
+
+	if err := s.checkAndPin("test-apb", "sha256:aaa"); err != nil {
+		t.Fatalf("expected first pin to succeed, got: %s", err)
+	}
+
+	if err := s.checkAndPin("test-apb", "sha256:aaa"); err != nil {
+		t.Fatalf("expected re-fetch at the same digest to succeed, got: %s", err)
+	}
+
+	err := s.checkAndPin("test-apb", "sha256:bbb")
+	if err == nil || !strings.Contains(err.Error(), "changed from sha256:aaa to sha256:bbb") {
+		t.Fatalf("expected a digest mismatch error, got: %v", err)
+	}
+}
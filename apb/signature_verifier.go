@@ -0,0 +1,203 @@
+package apb
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// sigLabelKey - label holding a base64-encoded detached signature over
+// the decoded com.redhat.apb.spec YAML.
+const sigLabelKey = "com.redhat.apb.spec.sig"
+
+// Supported values for Verification.TrustStoreType. trustStorePGP is the
+// default when TrustStoreType is unset.
+const (
+	trustStorePGP    = "pgp"
+	trustStoreCosign = "cosign"
+)
+
+// trustVerifier - checks a base64-encoded detached signature over data
+// against whichever keys a trust store was loaded with. Implemented by
+// pgpTrustVerifier and cosignTrustVerifier so verifySignature doesn't
+// need to know which kind of trust store is configured.
+type trustVerifier interface {
+	verify(data []byte, encodedSig string) error
+}
+
+// trustStore - loads and caches the trustVerifier a registryClient
+// verifies signatures against. Parsing a keyring isn't free, and
+// fetchSpecs may call verifySignature concurrently across its worker
+// pool, so the load happens exactly once.
+type trustStore struct {
+	once     sync.Once
+	verifier trustVerifier
+	err      error
+}
+
+func (t *trustStore) load(path, storeType string) (trustVerifier, error) {
+	t.once.Do(func() {
+		t.verifier, t.err = loadTrustVerifier(path, storeType)
+	})
+	return t.verifier, t.err
+}
+
+// verifySignature - validates labels.Sig, over the already-decoded
+// decodedSpec bytes, against the trust store named by
+// Verification.TrustStorePath, per Verification.SignatureMode. A mode of
+// "" is a no-op; an unrecognized mode is a configuration error rather
+// than silently falling through to "optional".
+func (c registryClient) verifySignature(labels *specLabels, decodedSpec []byte) error {
+	mode := c.config.Verification.SignatureMode
+	switch mode {
+	case "":
+		return nil
+	case "required", "optional":
+		// handled below
+	default:
+		return fmt.Errorf("unknown signature verification mode %q", mode)
+	}
+
+	if labels.Sig == "" {
+		if mode == "required" {
+			return fmt.Errorf("no %s label present and signature verification is required", sigLabelKey)
+		}
+		return nil
+	}
+
+	verifier, err := c.trust.load(c.config.Verification.TrustStorePath, c.config.Verification.TrustStoreType)
+	if err != nil {
+		return fmt.Errorf("could not load trust store: %s", err)
+	}
+
+	if err := verifier.verify(decodedSpec, labels.Sig); err != nil {
+		return fmt.Errorf("signature verification failed: %s", err)
+	}
+
+	return nil
+}
+
+// loadTrustVerifier - reads the trust store at trustStorePath and returns
+// the trustVerifier for trustStoreType: an armored PGP keyring for ""/
+// trustStorePGP, or one or more PEM-encoded ECDSA public keys for
+// trustStoreCosign (the format `cosign generate-key-pair` produces).
+func loadTrustVerifier(trustStorePath, trustStoreType string) (trustVerifier, error) {
+	if trustStorePath == "" {
+		return nil, fmt.Errorf("no trust store path configured")
+	}
+
+	switch trustStoreType {
+	case "", trustStorePGP:
+		keys, err := loadPGPKeyring(trustStorePath)
+		if err != nil {
+			return nil, err
+		}
+		return pgpTrustVerifier{keys: keys}, nil
+	case trustStoreCosign:
+		keys, err := loadCosignKeys(trustStorePath)
+		if err != nil {
+			return nil, err
+		}
+		return cosignTrustVerifier{keys: keys}, nil
+	default:
+		return nil, fmt.Errorf("unknown trust store type %q", trustStoreType)
+	}
+}
+
+// loadPGPKeyring - reads an armored PGP keyring (one or more concatenated
+// public keys) from trustStorePath.
+func loadPGPKeyring(trustStorePath string) (openpgp.EntityList, error) {
+	f, err := os.Open(trustStorePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// pgpTrustVerifier - verifies a base64-encoded detached PGP signature
+// against a trusted keyring.
+type pgpTrustVerifier struct {
+	keys openpgp.EntityList
+}
+
+func (v pgpTrustVerifier) verify(data []byte, encodedSig string) error {
+	sig, err := b64.StdEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return fmt.Errorf("error decoding signature label: %s", err)
+	}
+
+	_, err = openpgp.CheckDetachedSignature(v.keys, bytes.NewReader(data), bytes.NewReader(sig))
+	return err
+}
+
+// loadCosignKeys - reads one or more PEM-encoded ECDSA public keys
+// (`cosign generate-key-pair`'s cosign.pub, or several concatenated)
+// from trustStorePath.
+func loadCosignKeys(trustStorePath string) ([]*ecdsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(trustStorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*ecdsa.PublicKey
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cosign public key: %s", err)
+		}
+
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("cosign trust store must contain ECDSA public keys")
+		}
+		keys = append(keys, ecKey)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM-encoded public keys found in cosign trust store")
+	}
+
+	return keys, nil
+}
+
+// cosignTrustVerifier - verifies a base64-encoded, DER (ASN.1) ECDSA
+// signature over the SHA-256 digest of data, matching the detached
+// signature format `cosign sign-blob --key` produces, against a trusted
+// set of ECDSA public keys.
+type cosignTrustVerifier struct {
+	keys []*ecdsa.PublicKey
+}
+
+func (v cosignTrustVerifier) verify(data []byte, encodedSig string) error {
+	sig, err := b64.StdEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return fmt.Errorf("error decoding signature label: %s", err)
+	}
+
+	digest := sha256.Sum256(data)
+	for _, key := range v.keys {
+		if ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signature did not verify against any trusted cosign key")
+}
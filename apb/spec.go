@@ -0,0 +1,14 @@
+package apb
+
+// Spec - an APB spec decoded from an image's com.redhat.apb.spec label.
+type Spec struct {
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+
+	// Digest - the Docker-Content-Digest the manifest was fetched at.
+	// Populated by the registry, not sourced from the label itself, so
+	// that a previously-loaded Spec can be compared against a fresh
+	// fetch to detect a tag like `latest` being repointed at different
+	// content.
+	Digest string `yaml:"-"`
+}